@@ -10,8 +10,10 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"runtime"
 	"strconv"
+	"strings"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
@@ -23,13 +25,110 @@ type Processes struct {
 
 	forcePS   bool
 	forceProc bool
+
+	// bootTime caches the kernel boot time (Unix seconds), read once from
+	// /proc/stat's "btime" line by procBootTime.
+	bootTime int64
+
+	// Detailed enables per-process metrics (Linux only). When set, the
+	// plugin additionally emits a "procstat_all" measurement with one set of
+	// CPU/memory/IO fields per process, alongside the state-count rollup
+	// this plugin has always produced.
+	Detailed bool `toml:"detailed"`
+
+	// IncludePattern and ExcludePattern are regular expressions matched
+	// against each process' comm and cmdline. A process is only gathered in
+	// detailed mode if it matches IncludePattern (when set) and does not
+	// match ExcludePattern.
+	IncludePattern string `toml:"include_pattern"`
+	ExcludePattern string `toml:"exclude_pattern"`
+
+	// TopN, when greater than zero, limits detailed mode to the N processes
+	// with the highest recorded CPU time each interval, to keep cardinality
+	// bounded on hosts that run many short-lived processes.
+	TopN int `toml:"top_n"`
+
+	// CgroupPaths restricts cgroup accounting to cgroups whose resolved path
+	// matches one of these glob patterns ("**" matches across path
+	// separators, "*" does not), e.g.
+	// "/sys/fs/cgroup/**/kubepods/**". When set, gatherFromProc additionally
+	// emits a "processes" measurement per matched cgroup, tagged with
+	// "cgroup", alongside the global rollup.
+	CgroupPaths []string `toml:"cgroup_paths"`
+
+	// CgroupTagFrom selects whether the "cgroup" tag is the full resolved
+	// cgroup path ("path") or just its last path element ("name"). Defaults
+	// to "path".
+	CgroupTagFrom string `toml:"cgroup_tag_from"`
+
+	// CollectSmaps enables a "processes_memory" measurement aggregating
+	// RSS/PSS/swap across all processes from /proc/[pid]/smaps(_rollup).
+	// Off by default because parsing smaps is expensive on hosts with
+	// thousands of processes.
+	CollectSmaps bool `toml:"collect_smaps"`
+
+	// Method selects how process state is gathered: "proc" reads
+	// /proc/[pid]/stat directly, "ps" shells out to ps(1), and "taskstats"
+	// additionally pulls per-pid delay accounting from the kernel over a
+	// netlink socket (Linux only, requires CAP_NET_ADMIN) and folds the
+	// aggregated delay fields into the "processes" measurement. If the
+	// netlink socket can't be opened, taskstats logs the failure, omits the
+	// delay fields and gathers everything else as "proc" does.
+	// Defaults to "proc" on Linux and "ps" elsewhere.
+	Method string `toml:"method"`
+
+	includeRegexp  *regexp.Regexp
+	excludeRegexp  *regexp.Regexp
+	cgroupPatterns []*regexp.Regexp
 }
 
 func (p *Processes) Description() string {
 	return "Get the number of processes and group them by status"
 }
 
-func (p *Processes) SampleConfig() string { return "" }
+func (p *Processes) SampleConfig() string {
+	return `
+  ## Detailed enables per-process metrics (Linux only): CPU, memory, IO and
+  ## context-switch counts for every process that survives the
+  ## include_pattern/exclude_pattern filters below, as a "procstat_all"
+  ## measurement.
+  # detailed = false
+
+  ## Regular expressions matched against each process' comm and cmdline.
+  ## Detailed mode only gathers a process if it matches include_pattern
+  ## (when set) and does not match exclude_pattern.
+  # include_pattern = ""
+  # exclude_pattern = ""
+
+  ## Limits detailed mode to the top_n processes by CPU time, to keep
+  ## cardinality bounded on hosts that run many short-lived processes.
+  ## 0 (the default) gathers every process that passes the filters above.
+  # top_n = 0
+
+  ## Restricts cgroup accounting to cgroups whose resolved path matches one
+  ## of these glob patterns ("**" matches across path separators, "*" does
+  ## not), e.g. "/sys/fs/cgroup/**/kubepods/**". When set, an additional
+  ## "processes" measurement is emitted per matched cgroup, tagged with
+  ## "cgroup".
+  # cgroup_paths = []
+
+  ## Selects whether the "cgroup" tag is the full resolved cgroup path
+  ## ("path") or just its last path element ("name").
+  # cgroup_tag_from = "path"
+
+  ## Enables a "processes_memory" measurement aggregating RSS/PSS/swap
+  ## across all processes from /proc/[pid]/smaps(_rollup). Off by default
+  ## because parsing smaps is expensive on hosts with thousands of
+  ## processes.
+  # collect_smaps = false
+
+  ## Selects how process state is gathered: "proc" reads /proc/[pid]/stat
+  ## directly, "ps" shells out to ps(1), and "taskstats" additionally pulls
+  ## per-pid delay accounting from the kernel (Linux only, requires
+  ## CAP_NET_ADMIN). Defaults to "proc" on Linux and "ps" elsewhere.
+  # method = "proc"
+`
+}
 
 func (p *Processes) Gather(acc telegraf.Accumulator) error {
 	// Get an empty map of metric fields
@@ -45,6 +144,12 @@ func (p *Processes) Gather(acc telegraf.Accumulator) error {
 	} else if p.forceProc {
 		usePS = false
 	}
+	switch p.Method {
+	case "ps":
+		usePS = true
+	case "proc", "taskstats":
+		usePS = false
+	}
 
 	// Gather stats from 'ps' or procfs
 	if usePS {
@@ -52,12 +157,31 @@ func (p *Processes) Gather(acc telegraf.Accumulator) error {
 			return err
 		}
 	} else {
-		if err := p.gatherFromProc(fields); err != nil {
+		if err := p.gatherFromProc(acc, fields); err != nil {
 			return err
 		}
 	}
 
+	if p.Method == "taskstats" && runtime.GOOS == "linux" {
+		if err := p.gatherTaskstats(fields); err != nil {
+			log.Printf("processes: taskstats unavailable (%s), delay fields omitted", err)
+		}
+	}
+
 	acc.AddFields("processes", fields, nil)
+
+	if p.Detailed && runtime.GOOS == "linux" {
+		if err := p.gatherDetailed(acc); err != nil {
+			return err
+		}
+	}
+
+	if p.CollectSmaps && runtime.GOOS == "linux" {
+		if err := p.gatherSmaps(acc); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -82,6 +206,10 @@ func getEmptyFields() map[string]interface{} {
 	case "linux":
 		fields["paging"] = int64(0)
 		fields["total_threads"] = int64(0)
+		fields["kernel_threads"] = int64(0)
+		fields["user_processes"] = int64(0)
+		fields["max_tree_depth"] = int64(0)
+		fields["max_children"] = int64(0)
 	}
 	return fields
 }
@@ -124,12 +252,19 @@ func (p *Processes) gatherFromPS(fields map[string]interface{}) error {
 }
 
 // get process states from /proc/(pid)/stat files
-func (p *Processes) gatherFromProc(fields map[string]interface{}) error {
+func (p *Processes) gatherFromProc(acc telegraf.Accumulator, fields map[string]interface{}) error {
+	if err := p.compileCgroupPatterns(); err != nil {
+		return err
+	}
+
 	files, err := ioutil.ReadDir("/proc")
 	if err != nil {
 		return err
 	}
 
+	cgroupFields := make(map[string]map[string]interface{})
+	ppidOf := make(map[int]int)
+
 	for _, file := range files {
 		if !file.IsDir() {
 			continue
@@ -144,39 +279,160 @@ func (p *Processes) gatherFromProc(fields map[string]interface{}) error {
 			continue
 		}
 
-		stats := bytes.Fields(data)
-		if len(stats) < 3 {
+		stats, err := splitStatFields(data)
+		if err != nil {
+			return fmt.Errorf("%s: %s", statFile, err)
+		}
+		if len(stats) < 20 {
 			return fmt.Errorf("Something is terribly wrong with %s", statFile)
 		}
-		switch stats[2][0] {
-		case 'R':
-			fields["running"] = fields["running"].(int64) + int64(1)
-		case 'S':
-			fields["sleeping"] = fields["sleeping"].(int64) + int64(1)
-		case 'D':
-			fields["blocked"] = fields["blocked"].(int64) + int64(1)
-		case 'Z':
-			fields["zombies"] = fields["zombies"].(int64) + int64(1)
-		case 'T', 't':
-			fields["stopped"] = fields["stopped"].(int64) + int64(1)
-		case 'W':
-			fields["paging"] = fields["paging"].(int64) + int64(1)
-		default:
-			log.Printf("processes: Unknown state [ %s ] in file %s",
-				string(stats[2][0]), statFile)
+
+		if pid, err := strconv.Atoi(string(stats[0])); err == nil {
+			if ppid, err := strconv.Atoi(string(stats[3])); err == nil {
+				ppidOf[pid] = ppid
+			}
 		}
-		fields["total"] = fields["total"].(int64) + int64(1)
 
-		threads, err := strconv.Atoi(string(stats[19]))
-		if err != nil {
-			log.Printf("processes: Error parsing thread count: %s", err)
-			continue
+		isKernelThread := isKernelThreadStat(stats)
+
+		var group map[string]interface{}
+		if len(p.cgroupPatterns) > 0 {
+			cgroup, err := p.resolveCgroup(file.Name())
+			if err != nil {
+				log.Printf("processes: Error resolving cgroup for pid %s: %s", file.Name(), err)
+			} else if cgroup != "" {
+				group = cgroupFields[cgroup]
+				if group == nil {
+					group = getEmptyFields()
+					cgroupFields[cgroup] = group
+				}
+			}
+		}
+
+		threads, threadsErr := strconv.Atoi(string(stats[19]))
+		if threadsErr != nil {
+			log.Printf("processes: Error parsing thread count: %s", threadsErr)
+		}
+
+		for _, f := range []map[string]interface{}{fields, group} {
+			if f == nil {
+				continue
+			}
+			switch stats[2][0] {
+			case 'R':
+				f["running"] = f["running"].(int64) + int64(1)
+			case 'S':
+				f["sleeping"] = f["sleeping"].(int64) + int64(1)
+			case 'D':
+				f["blocked"] = f["blocked"].(int64) + int64(1)
+			case 'Z':
+				f["zombies"] = f["zombies"].(int64) + int64(1)
+			case 'T', 't':
+				f["stopped"] = f["stopped"].(int64) + int64(1)
+			case 'W':
+				f["paging"] = f["paging"].(int64) + int64(1)
+			default:
+				log.Printf("processes: Unknown state [ %s ] in file %s",
+					string(stats[2][0]), statFile)
+			}
+			f["total"] = f["total"].(int64) + int64(1)
+
+			if isKernelThread {
+				f["kernel_threads"] = f["kernel_threads"].(int64) + int64(1)
+			} else {
+				f["user_processes"] = f["user_processes"].(int64) + int64(1)
+			}
+
+			if threadsErr == nil {
+				f["total_threads"] = f["total_threads"].(int64) + int64(threads)
+			}
 		}
-		fields["total_threads"] = fields["total_threads"].(int64) + int64(threads)
 	}
+
+	for cgroup, f := range cgroupFields {
+		acc.AddFields("processes", f, map[string]string{"cgroup": cgroup})
+	}
+
+	maxDepth, maxChildren := processTreeStats(ppidOf)
+	fields["max_tree_depth"] = maxDepth
+	fields["max_children"] = maxChildren
+
 	return nil
 }
 
+// splitStatFields splits a raw /proc/[pid]/stat line into fields, locating
+// comm by its enclosing parentheses rather than splitting on whitespace
+// throughout: comm may itself contain spaces or parentheses (e.g. "(my
+// thread)"), which would otherwise shift every subsequent index. The
+// returned slice keeps the same indices callers have always used: index 0 is
+// pid, index 1 is the untouched "(comm)" token, and index 2 onward are the
+// remaining fields in proc(5) order starting at state (field 3).
+func splitStatFields(data []byte) ([][]byte, error) {
+	line := string(data)
+	open := strings.IndexByte(line, '(')
+	end := strings.LastIndexByte(line, ')')
+	if open < 0 || end < 0 || end < open {
+		return nil, fmt.Errorf("malformed stat line: missing comm delimiters")
+	}
+
+	fields := [][]byte{[]byte(strings.TrimSpace(line[:open])), []byte(line[open : end+1])}
+	fields = append(fields, bytes.Fields([]byte(line[end+1:]))...)
+	return fields, nil
+}
+
+// pfKthread is the /proc/[pid]/stat flags (field 9) bit marking kernel
+// threads, which have no address space of their own and shouldn't be
+// counted as userland processes.
+const pfKthread = 0x00200000
+
+// isKernelThreadStat reports whether stats, as returned by splitStatFields,
+// describes a kernel thread. This is the one PF_KTHREAD check every gatherer
+// that needs to tell kernel threads from userland processes shares.
+func isKernelThreadStat(stats [][]byte) bool {
+	flags, err := strconv.ParseInt(string(stats[8]), 10, 64)
+	return err == nil && flags&pfKthread != 0
+}
+
+// processTreeStats walks a pid -> ppid map built from /proc and returns the
+// deepest chain of ancestry (max_tree_depth) and the largest number of
+// children any single pid has (max_children). PID 1 (or 2 for kthreadd) is
+// the conceptual root; true cycles cannot occur in a real process tree, but
+// each walk still carries its own visited set in case /proc was read mid-fork
+// bomb and produced something inconsistent.
+func processTreeStats(ppidOf map[int]int) (maxDepth int64, maxChildren int64) {
+	childCount := make(map[int]int)
+	for _, ppid := range ppidOf {
+		childCount[ppid]++
+	}
+	for _, n := range childCount {
+		if int64(n) > maxChildren {
+			maxChildren = int64(n)
+		}
+	}
+
+	for pid := range ppidOf {
+		visited := map[int]bool{pid: true}
+		depth := int64(0)
+		cur := pid
+		for {
+			parent, ok := ppidOf[cur]
+			// pid 0 is the kernel's idle task, the ppid of PID 1 and 2; it is
+			// not part of the tree and adds no depth.
+			if !ok || parent == 0 || parent == cur || visited[parent] {
+				break
+			}
+			visited[parent] = true
+			cur = parent
+			depth++
+		}
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+
+	return maxDepth, maxChildren
+}
+
 func readProcFile(statFile string) ([]byte, error) {
 	if _, err := os.Stat(statFile); os.IsNotExist(err) {
 		return nil, nil
@@ -206,6 +462,96 @@ func execPS() ([]byte, error) {
 	return out, err
 }
 
+// cgroupRoot is where the cgroup filesystem is conventionally mounted; it is
+// prepended to the paths read from /proc/[pid]/cgroup to build the absolute
+// paths matched against CgroupPaths.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// compileCgroupPatterns lazily turns CgroupPaths globs into regexps.
+func (p *Processes) compileCgroupPatterns() error {
+	if len(p.CgroupPaths) != len(p.cgroupPatterns) {
+		p.cgroupPatterns = make([]*regexp.Regexp, 0, len(p.CgroupPaths))
+		for _, pattern := range p.CgroupPaths {
+			re, err := regexp.Compile(globToRegexp(pattern))
+			if err != nil {
+				return fmt.Errorf("processes: invalid cgroup_paths pattern %q: %s", pattern, err)
+			}
+			p.cgroupPatterns = append(p.cgroupPatterns, re)
+		}
+	}
+	return nil
+}
+
+// globToRegexp converts a glob pattern using "*" (any run of characters
+// except '/') and "**" (any run of characters, including '/') into an
+// anchored regexp.
+func globToRegexp(pattern string) string {
+	var b bytes.Buffer
+	b.WriteByte('^')
+	i := 0
+	for i < len(pattern) {
+		c := pattern[i]
+		switch {
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			b.WriteString(".*")
+			i += 2
+		case c == '*':
+			b.WriteString("[^/]*")
+			i++
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			i++
+		}
+	}
+	b.WriteByte('$')
+	return b.String()
+}
+
+// resolveCgroup reads /proc/[pid]/cgroup and returns the tag value for the
+// first cgroup whose absolute path matches one of p.cgroupPatterns, or "" if
+// none match.
+func (p *Processes) resolveCgroup(pid string) (string, error) {
+	data, err := p.readProcFile(path.Join("/proc", pid, "cgroup"))
+	if err != nil || data == nil {
+		return "", err
+	}
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fields := bytes.SplitN(line, []byte(":"), 3)
+		if len(fields) != 3 {
+			continue
+		}
+		controllers, cgPath := string(fields[1]), string(fields[2])
+
+		var candidates []string
+		if controllers == "" {
+			// cgroup v2 unified hierarchy
+			candidates = []string{path.Join(cgroupRoot, cgPath)}
+		} else {
+			for _, c := range strings.Split(controllers, ",") {
+				// The systemd cgroup driver reports its named hierarchy as
+				// "name=systemd" in /proc/[pid]/cgroup, but mounts it at
+				// .../cgroup/systemd, not .../cgroup/name=systemd.
+				c = strings.TrimPrefix(c, "name=")
+				candidates = append(candidates, path.Join(cgroupRoot, c, cgPath))
+			}
+		}
+
+		for _, candidate := range candidates {
+			for _, re := range p.cgroupPatterns {
+				if re.MatchString(candidate) {
+					if p.CgroupTagFrom == "name" {
+						return path.Base(candidate), nil
+					}
+					return candidate, nil
+				}
+			}
+		}
+	}
+
+	return "", nil
+}
+
 func init() {
 	inputs.Add("processes", func() telegraf.Input {
 		return &Processes{
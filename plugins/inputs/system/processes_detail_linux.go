@@ -0,0 +1,308 @@
+package system
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+)
+
+// clockTicks is the number of scheduler clock ticks per second (the kernel's
+// USER_HZ, exposed to userspace as _SC_CLK_TCK). It has been 100 on every
+// architecture Linux supports for well over a decade, so it is cached here
+// rather than pulled in via cgo just to call sysconf(3). It converts the
+// ticks-since-boot values /proc/[pid]/stat reports (e.g. start_time) into
+// seconds.
+const clockTicks = int64(100)
+
+// pageSize is the size, in bytes, of a single memory page. /proc/[pid]/statm
+// reports memory usage in pages.
+var pageSize = int64(os.Getpagesize())
+
+// procDetail holds the fields gathered for a single process.
+type procDetail struct {
+	pid  string
+	comm string
+
+	ppid int64
+	uid  int64
+	nice int64
+
+	utime, stime          int64
+	numThreads            int64
+	startTime             int64
+	voluntaryCtxtSwitches int64
+	involuntaryCtxtSwitch int64
+	minflt, majflt        int64
+
+	vmSize, vmRSS, vmShared, vmText, vmData int64
+
+	readBytes, writeBytes int64
+	numFDs                int64
+}
+
+// procBootTime returns the kernel boot time as Unix seconds, read from
+// /proc/stat's "btime" line and cached on p for the life of the plugin.
+func (p *Processes) procBootTime() (int64, error) {
+	if p.bootTime != 0 {
+		return p.bootTime, nil
+	}
+
+	data, err := p.readProcFile("/proc/stat")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "btime ") {
+			continue
+		}
+		btime, err := strconv.ParseInt(strings.TrimSpace(line[len("btime "):]), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		p.bootTime = btime
+		return p.bootTime, nil
+	}
+
+	return 0, fmt.Errorf("processes: no btime line in /proc/stat")
+}
+
+// compileFilters lazily compiles IncludePattern/ExcludePattern so detailed
+// gathers don't pay regexp.Compile on every interval.
+func (p *Processes) compileFilters() error {
+	if p.IncludePattern != "" && p.includeRegexp == nil {
+		re, err := regexp.Compile(p.IncludePattern)
+		if err != nil {
+			return fmt.Errorf("processes: invalid include_pattern: %s", err)
+		}
+		p.includeRegexp = re
+	}
+	if p.ExcludePattern != "" && p.excludeRegexp == nil {
+		re, err := regexp.Compile(p.ExcludePattern)
+		if err != nil {
+			return fmt.Errorf("processes: invalid exclude_pattern: %s", err)
+		}
+		p.excludeRegexp = re
+	}
+	return nil
+}
+
+func (p *Processes) matchesFilters(comm, cmdline string) bool {
+	if p.includeRegexp != nil && !p.includeRegexp.MatchString(comm) && !p.includeRegexp.MatchString(cmdline) {
+		return false
+	}
+	if p.excludeRegexp != nil && (p.excludeRegexp.MatchString(comm) || p.excludeRegexp.MatchString(cmdline)) {
+		return false
+	}
+	return true
+}
+
+// gatherDetailed walks /proc and emits one "procstat_all" measurement per
+// process that survives the include/exclude filters, optionally limited to
+// the top_n heaviest processes by CPU time.
+func (p *Processes) gatherDetailed(acc telegraf.Accumulator) error {
+	if err := p.compileFilters(); err != nil {
+		return err
+	}
+
+	// d.startTime is ticks since boot (proc(5) field 22); converting it to
+	// an epoch timestamp needs the kernel's boot time too. If /proc/stat is
+	// unreadable, log and fall back to reporting seconds since boot rather
+	// than dropping every other detailed field over one missing value.
+	bootTime, err := p.procBootTime()
+	if err != nil {
+		log.Printf("processes: reading boot time: %s; start_time will be seconds since boot", err)
+	}
+
+	files, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return err
+	}
+
+	procs := make([]*procDetail, 0, len(files))
+	for _, file := range files {
+		pid := file.Name()
+		if !file.IsDir() {
+			continue
+		}
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+
+		d, cmdline, err := p.readProcDetail(pid)
+		if err != nil || d == nil {
+			// Processes routinely exit between the readdir and the read;
+			// that's expected and not worth logging every interval.
+			continue
+		}
+
+		if !p.matchesFilters(d.comm, cmdline) {
+			continue
+		}
+
+		procs = append(procs, d)
+	}
+
+	if p.TopN > 0 && len(procs) > p.TopN {
+		sort.Slice(procs, func(i, j int) bool {
+			return (procs[i].utime + procs[i].stime) > (procs[j].utime + procs[j].stime)
+		})
+		procs = procs[:p.TopN]
+	}
+
+	for _, d := range procs {
+		tags := map[string]string{
+			"pid":  d.pid,
+			"comm": d.comm,
+		}
+		fields := map[string]interface{}{
+			"cpu_time_user":                d.utime,
+			"cpu_time_system":              d.stime,
+			"memory_rss":                   d.vmRSS * pageSize,
+			"memory_vms":                   d.vmSize,
+			"memory_shared":                d.vmShared * pageSize,
+			"memory_text":                  d.vmText * pageSize,
+			"memory_data":                  d.vmData * pageSize,
+			"num_threads":                  d.numThreads,
+			"voluntary_context_switches":   d.voluntaryCtxtSwitches,
+			"involuntary_context_switches": d.involuntaryCtxtSwitch,
+			"minor_faults":                 d.minflt,
+			"major_faults":                 d.majflt,
+			"read_bytes":                   d.readBytes,
+			"write_bytes":                  d.writeBytes,
+			"num_fds":                      d.numFDs,
+			"ppid":                         d.ppid,
+			"uid":                          d.uid,
+			"nice":                         d.nice,
+			"start_time":                   bootTime + d.startTime/clockTicks,
+		}
+		acc.AddFields("procstat_all", fields, tags)
+	}
+
+	return nil
+}
+
+// readProcDetail parses /proc/[pid]/stat, status, statm and io for pid,
+// returning nil without error if the process exited mid-read. The raw
+// cmdline is returned separately since it is only needed for filtering.
+func (p *Processes) readProcDetail(pid string) (*procDetail, string, error) {
+	statData, err := p.readProcFile(path.Join("/proc", pid, "stat"))
+	if err != nil {
+		return nil, "", err
+	}
+	if statData == nil {
+		return nil, "", nil
+	}
+
+	d := &procDetail{pid: pid}
+	if err := d.parseStat(statData); err != nil {
+		return nil, "", err
+	}
+
+	if statusData, err := p.readProcFile(path.Join("/proc", pid, "status")); err == nil && statusData != nil {
+		d.parseStatus(statusData)
+	}
+
+	if statmData, err := p.readProcFile(path.Join("/proc", pid, "statm")); err == nil && statmData != nil {
+		d.parseStatm(statmData)
+	}
+
+	if ioData, err := p.readProcFile(path.Join("/proc", pid, "io")); err == nil && ioData != nil {
+		d.parseIO(ioData)
+	}
+
+	if fds, err := ioutil.ReadDir(path.Join("/proc", pid, "fd")); err == nil {
+		d.numFDs = int64(len(fds))
+	}
+
+	cmdlineData, _ := p.readProcFile(path.Join("/proc", pid, "cmdline"))
+	cmdline := strings.ReplaceAll(string(cmdlineData), "\x00", " ")
+
+	return d, cmdline, nil
+}
+
+// parseStat fills in the fields sourced from /proc/[pid]/stat, delegating
+// the comm-safe split to splitStatFields rather than re-parsing the line
+// itself: comm may contain spaces or parentheses, and that delimiting logic
+// should only live in one place.
+func (d *procDetail) parseStat(data []byte) error {
+	fields, err := splitStatFields(data)
+	if err != nil {
+		return fmt.Errorf("processes: %s for pid %s", err, d.pid)
+	}
+	// fields[2] is state; ppid is fields[3], and so on following the
+	// documented /proc/[pid]/stat field order starting at field 3.
+	if len(fields) < 22 {
+		return fmt.Errorf("processes: short stat line for pid %s", d.pid)
+	}
+
+	d.comm = string(fields[1][1 : len(fields[1])-1])
+	d.ppid, _ = strconv.ParseInt(string(fields[3]), 10, 64)
+	d.minflt, _ = strconv.ParseInt(string(fields[9]), 10, 64)
+	d.majflt, _ = strconv.ParseInt(string(fields[11]), 10, 64)
+	d.utime, _ = strconv.ParseInt(string(fields[13]), 10, 64)
+	d.stime, _ = strconv.ParseInt(string(fields[14]), 10, 64)
+	d.nice, _ = strconv.ParseInt(string(fields[18]), 10, 64)
+	d.numThreads, _ = strconv.ParseInt(string(fields[19]), 10, 64)
+	d.startTime, _ = strconv.ParseInt(string(fields[21]), 10, 64)
+
+	return nil
+}
+
+// parseStatus fills in the fields sourced from /proc/[pid]/status. Unknown
+// lines are ignored so this stays forward-compatible with newer kernels.
+func (d *procDetail) parseStatus(data []byte) {
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "Uid:":
+			d.uid, _ = strconv.ParseInt(fields[1], 10, 64)
+		case "voluntary_ctxt_switches:":
+			d.voluntaryCtxtSwitches, _ = strconv.ParseInt(fields[1], 10, 64)
+		case "nonvoluntary_ctxt_switches:":
+			d.involuntaryCtxtSwitch, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+}
+
+// parseStatm fills in the memory fields sourced from /proc/[pid]/statm,
+// which reports size, resident, shared, text, lib and data in pages.
+func (d *procDetail) parseStatm(data []byte) {
+	fields := strings.Fields(string(data))
+	if len(fields) < 6 {
+		return
+	}
+	d.vmSize, _ = strconv.ParseInt(fields[0], 10, 64)
+	d.vmRSS, _ = strconv.ParseInt(fields[1], 10, 64)
+	d.vmShared, _ = strconv.ParseInt(fields[2], 10, 64)
+	d.vmText, _ = strconv.ParseInt(fields[3], 10, 64)
+	d.vmData, _ = strconv.ParseInt(fields[5], 10, 64)
+	d.vmSize *= pageSize
+}
+
+// parseIO fills in the IO fields sourced from /proc/[pid]/io.
+func (d *procDetail) parseIO(data []byte) {
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "read_bytes:":
+			d.readBytes, _ = strconv.ParseInt(fields[1], 10, 64)
+		case "write_bytes:":
+			d.writeBytes, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+}
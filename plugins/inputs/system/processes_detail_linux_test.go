@@ -0,0 +1,116 @@
+package system
+
+import "testing"
+
+func TestProcBootTime(t *testing.T) {
+	p := &Processes{
+		readProcFile: func(string) ([]byte, error) {
+			return []byte("cpu  100 0 200 300\nctxt 12345\nbtime 1700000000\nprocesses 42\n"), nil
+		},
+	}
+
+	got, err := p.procBootTime()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got != 1700000000 {
+		t.Errorf("procBootTime = %d, want 1700000000", got)
+	}
+
+	// Cached: a second call must not re-read /proc/stat.
+	p.readProcFile = func(string) ([]byte, error) {
+		t.Fatal("procBootTime should not re-read /proc/stat once cached")
+		return nil, nil
+	}
+	if got, err := p.procBootTime(); err != nil || got != 1700000000 {
+		t.Errorf("procBootTime (cached) = %d, %v, want 1700000000, nil", got, err)
+	}
+}
+
+func TestProcBootTimeMissingBtime(t *testing.T) {
+	p := &Processes{
+		readProcFile: func(string) ([]byte, error) {
+			return []byte("cpu  100 0 200 300\n"), nil
+		},
+	}
+
+	if _, err := p.procBootTime(); err == nil {
+		t.Fatal("expected an error when /proc/stat has no btime line")
+	}
+}
+
+func TestProcDetailParseStat(t *testing.T) {
+	// comm contains a space and a paren, which must not shift any of the
+	// fixed-index fields read after it.
+	line := []byte("4321 (my thread (worker)) S 42 4321 4321 0 -1 4194304 1 2 3 4 55 66 0 0 20 0 7 0 99999 0 0")
+
+	d := &procDetail{pid: "4321"}
+	if err := d.parseStat(line); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if d.comm != "my thread (worker)" {
+		t.Errorf("comm = %q, want %q", d.comm, "my thread (worker)")
+	}
+	if d.ppid != 42 {
+		t.Errorf("ppid = %d, want 42", d.ppid)
+	}
+	if d.minflt != 1 {
+		t.Errorf("minflt = %d, want 1", d.minflt)
+	}
+	if d.majflt != 3 {
+		t.Errorf("majflt = %d, want 3", d.majflt)
+	}
+	if d.utime != 55 {
+		t.Errorf("utime = %d, want 55", d.utime)
+	}
+	if d.stime != 66 {
+		t.Errorf("stime = %d, want 66", d.stime)
+	}
+	if d.numThreads != 7 {
+		t.Errorf("numThreads = %d, want 7", d.numThreads)
+	}
+	if d.startTime != 99999 {
+		t.Errorf("startTime = %d, want 99999", d.startTime)
+	}
+}
+
+func TestProcDetailParseStatMalformed(t *testing.T) {
+	d := &procDetail{pid: "1"}
+	if err := d.parseStat([]byte("1 no-parens S 0")); err == nil {
+		t.Fatal("expected an error for a stat line without comm delimiters")
+	}
+}
+
+func TestProcDetailParseStatm(t *testing.T) {
+	d := &procDetail{}
+	d.parseStatm([]byte("100 50 10 5 0 20\n"))
+
+	if d.vmSize != 100*pageSize {
+		t.Errorf("vmSize = %d, want %d", d.vmSize, 100*pageSize)
+	}
+	if d.vmRSS != 50 {
+		t.Errorf("vmRSS = %d, want 50", d.vmRSS)
+	}
+	if d.vmShared != 10 {
+		t.Errorf("vmShared = %d, want 10", d.vmShared)
+	}
+	if d.vmText != 5 {
+		t.Errorf("vmText = %d, want 5", d.vmText)
+	}
+	if d.vmData != 20 {
+		t.Errorf("vmData = %d, want 20", d.vmData)
+	}
+}
+
+func TestProcDetailParseIO(t *testing.T) {
+	d := &procDetail{}
+	d.parseIO([]byte("rchar: 1\nwchar: 2\nsyscr: 3\nsyscw: 4\nread_bytes: 500\nwrite_bytes: 600\ncancelled_write_bytes: 0\n"))
+
+	if d.readBytes != 500 {
+		t.Errorf("readBytes = %d, want 500", d.readBytes)
+	}
+	if d.writeBytes != 600 {
+		t.Errorf("writeBytes = %d, want 600", d.writeBytes)
+	}
+}
@@ -0,0 +1,86 @@
+package system
+
+import "testing"
+
+func TestParseSmapsRollup(t *testing.T) {
+	// A trimmed but representative smaps_rollup file: one mapping header
+	// line (no trailing colon, must be skipped) followed by the fields we
+	// care about.
+	data := []byte(`00400000-7ffe00000000 ---p 00000000 00:00 0                  [rollup]
+Rss:               10240 kB
+Pss:                5120 kB
+Shared_Clean:       2048 kB
+Shared_Dirty:        512 kB
+Private_Clean:      1024 kB
+Private_Dirty:      6656 kB
+Referenced:         9000 kB
+Anonymous:          4000 kB
+Swap:                256 kB
+`)
+
+	var totals smapsTotals
+	parseSmaps(data, &totals)
+
+	cases := map[string]int64{
+		"rss":          10240,
+		"pss":          5120,
+		"sharedClean":  2048,
+		"sharedDirty":  512,
+		"privateClean": 1024,
+		"privateDirty": 6656,
+		"referenced":   9000,
+		"anonymous":    4000,
+		"swap":         256,
+	}
+	got := map[string]int64{
+		"rss":          totals.rss,
+		"pss":          totals.pss,
+		"sharedClean":  totals.sharedClean,
+		"sharedDirty":  totals.sharedDirty,
+		"privateClean": totals.privateClean,
+		"privateDirty": totals.privateDirty,
+		"referenced":   totals.referenced,
+		"anonymous":    totals.anonymous,
+		"swap":         totals.swap,
+	}
+	for key, want := range cases {
+		if got[key] != want {
+			t.Errorf("%s = %d, want %d", key, got[key], want)
+		}
+	}
+}
+
+func TestParseSmapsSumsMultipleMappings(t *testing.T) {
+	// smaps (as opposed to smaps_rollup) has one block of fields per
+	// mapping; the per-process total must be the sum across all of them.
+	data := []byte(`00400000-00401000 r-xp 00000000 00:00 0  a.out
+Rss:                 100 kB
+Pss:                  50 kB
+00600000-00601000 rw-p 00000000 00:00 0  a.out
+Rss:                 200 kB
+Pss:                 200 kB
+`)
+
+	var totals smapsTotals
+	parseSmaps(data, &totals)
+
+	if totals.rss != 300 {
+		t.Errorf("rss = %d, want 300 (sum across both mappings)", totals.rss)
+	}
+	if totals.pss != 250 {
+		t.Errorf("pss = %d, want 250 (sum across both mappings)", totals.pss)
+	}
+}
+
+func TestParseSmapsIgnoresMappingHeaderLines(t *testing.T) {
+	// Mapping header lines look like "<addr range> <perms> ... <path>" and
+	// must not be mistaken for a "Key: value kB" field line.
+	data := []byte("7f0000000000-7f0000001000 r--p 00000000 08:01 1234 /lib/libc.so\nRss: 4 kB\n")
+
+	var totals smapsTotals
+	parseSmaps(data, &totals)
+
+	if totals.rss != 4 {
+		t.Errorf("rss = %d, want 4", totals.rss)
+	}
+}
@@ -0,0 +1,90 @@
+package system
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// syntheticTaskstats builds a struct taskstats byte buffer with sentinel
+// values planted at the documented offsets, mirroring the layout described
+// above the off* constants in processes_taskstats_linux.go.
+func syntheticTaskstats() []byte {
+	buf := make([]byte, offHiwaterRSS+8)
+	binary.LittleEndian.PutUint64(buf[offCPUDelayTotal:], 111)
+	binary.LittleEndian.PutUint64(buf[offBlkioDelayTotal:], 222)
+	binary.LittleEndian.PutUint64(buf[offSwapinDelayTotal:], 333)
+	binary.LittleEndian.PutUint64(buf[offHiwaterRSS:], 444)
+	return buf
+}
+
+func TestParseTaskstats(t *testing.T) {
+	d, err := parseTaskstats(syntheticTaskstats())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if d.cpuDelayNs != 111 {
+		t.Errorf("cpuDelayNs = %d, want 111", d.cpuDelayNs)
+	}
+	if d.blkioDelayNs != 222 {
+		t.Errorf("blkioDelayNs = %d, want 222", d.blkioDelayNs)
+	}
+	if d.swapinDelayNs != 333 {
+		t.Errorf("swapinDelayNs = %d, want 333", d.swapinDelayNs)
+	}
+	if d.hiwaterRSS != 444 {
+		t.Errorf("hiwaterRSS = %d, want 444 (offHiwaterRSS must stay 200, not drift onto write_char)", d.hiwaterRSS)
+	}
+}
+
+func TestParseTaskstatsShortPayload(t *testing.T) {
+	if _, err := parseTaskstats(make([]byte, offHiwaterRSS)); err == nil {
+		t.Fatal("expected an error for a payload too short to contain hiwater_rss")
+	}
+}
+
+func TestAlign4(t *testing.T) {
+	cases := map[int]int{0: 0, 1: 4, 2: 4, 3: 4, 4: 4, 5: 8, 8: 8, 9: 12}
+	for n, want := range cases {
+		if got := align4(n); got != want {
+			t.Errorf("align4(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestPackAndParseNlAttr(t *testing.T) {
+	packed := packNlAttr(7, []byte{0xaa, 0xbb, 0xcc})
+
+	// The payload is 3 bytes but must be padded to a 4-byte boundary.
+	if len(packed)%4 != 0 {
+		t.Fatalf("packed attribute length %d is not 4-byte aligned", len(packed))
+	}
+
+	attrs := parseAttrs(packed)
+	got, ok := attrs[7]
+	if !ok {
+		t.Fatalf("attribute type 7 missing from parsed attrs: %v", attrs)
+	}
+	want := []byte{0xaa, 0xbb, 0xcc}
+	if len(got) != len(want) {
+		t.Fatalf("payload = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("payload = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestParseAttrsMultiple(t *testing.T) {
+	buf := append(packNlAttr(1, []byte{0x01}), packNlAttr(2, []byte{0x02, 0x03})...)
+	attrs := parseAttrs(buf)
+	if len(attrs) != 2 {
+		t.Fatalf("got %d attrs, want 2: %v", len(attrs), attrs)
+	}
+	if attrs[1][0] != 0x01 {
+		t.Errorf("attrs[1] = %v, want [0x01]", attrs[1])
+	}
+	if attrs[2][0] != 0x02 || attrs[2][1] != 0x03 {
+		t.Errorf("attrs[2] = %v, want [0x02 0x03]", attrs[2])
+	}
+}
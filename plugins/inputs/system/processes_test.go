@@ -0,0 +1,146 @@
+// +build !windows
+
+package system
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestSplitStatFieldsCommWithSpaces(t *testing.T) {
+	// A kernel thread or renamed process can put spaces and even parens
+	// inside comm, e.g. prctl(PR_SET_NAME, "my thread (worker)").
+	line := []byte("1234 (my thread (worker)) S 1 1234 1234 0 -1 4194560 10 0 0 0 5 6 0 0 20 0 4 0 12345 0 0")
+
+	fields, err := splitStatFields(line)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(fields) < 20 {
+		t.Fatalf("got %d fields, want at least 20: %v", len(fields), fields)
+	}
+
+	if string(fields[0]) != "1234" {
+		t.Errorf("pid field = %q, want %q", fields[0], "1234")
+	}
+	if string(fields[1]) != "(my thread (worker))" {
+		t.Errorf("comm field = %q, want %q", fields[1], "(my thread (worker))")
+	}
+	// state is the first field after comm, regardless of how many spaces
+	// comm itself contained.
+	if string(fields[2]) != "S" {
+		t.Errorf("state field = %q, want %q", fields[2], "S")
+	}
+	// ppid (field 4) must land at index 3, not be thrown off by the spaces
+	// inside comm.
+	if string(fields[3]) != "1" {
+		t.Errorf("ppid field = %q, want %q", fields[3], "1")
+	}
+}
+
+func TestSplitStatFieldsMalformed(t *testing.T) {
+	if _, err := splitStatFields([]byte("1234 no-parens-here S 1")); err == nil {
+		t.Fatal("expected an error for a stat line without comm delimiters")
+	}
+}
+
+func TestIsKernelThreadStat(t *testing.T) {
+	line := []byte("2 (kthreadd) S 0 0 0 0 -1 2129984 0 0 0 0 0 0 0 0 20 0 1 0 50 0 0")
+	stats, err := splitStatFields(line)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !isKernelThreadStat(stats) {
+		t.Error("isKernelThreadStat = false, want true for a PF_KTHREAD flags value")
+	}
+
+	line = []byte("1234 (bash) S 1 1234 1234 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 50 0 0")
+	stats, err = splitStatFields(line)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if isKernelThreadStat(stats) {
+		t.Error("isKernelThreadStat = true, want false without the PF_KTHREAD bit set")
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"/sys/fs/cgroup/**/kubepods/**", "/sys/fs/cgroup/memory/kubepods/pod1/abcd", true},
+		{"/sys/fs/cgroup/**/kubepods/**", "/sys/fs/cgroup/memory/other/pod1", false},
+		{"/sys/fs/cgroup/systemd/**", "/sys/fs/cgroup/systemd/user.slice", true},
+		{"/sys/fs/cgroup/systemd/*", "/sys/fs/cgroup/systemd/a/b", false},
+	}
+	for _, c := range cases {
+		re := regexp.MustCompile(globToRegexp(c.pattern))
+		if got := re.MatchString(c.path); got != c.match {
+			t.Errorf("globToRegexp(%q).MatchString(%q) = %v, want %v", c.pattern, c.path, got, c.match)
+		}
+	}
+}
+
+func TestResolveCgroupStripsSystemdNamePrefix(t *testing.T) {
+	p := &Processes{
+		CgroupPaths: []string{"/sys/fs/cgroup/systemd/**"},
+		readProcFile: func(string) ([]byte, error) {
+			return []byte("1:name=systemd:/user.slice/user-1000.slice\n"), nil
+		},
+	}
+	if err := p.compileCgroupPatterns(); err != nil {
+		t.Fatalf("compileCgroupPatterns: %s", err)
+	}
+
+	cgroup, err := p.resolveCgroup("1234")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := "/sys/fs/cgroup/systemd/user.slice/user-1000.slice"
+	if cgroup != want {
+		t.Errorf("cgroup = %q, want %q (the name= prefix should be stripped before joining the mount path)", cgroup, want)
+	}
+}
+
+func TestProcessTreeStats(t *testing.T) {
+	// 1 -> 100 -> 101, 102; 1 -> 200
+	ppidOf := map[int]int{
+		100: 1,
+		101: 100,
+		102: 100,
+		200: 1,
+	}
+
+	maxDepth, maxChildren := processTreeStats(ppidOf)
+	if maxDepth != 2 {
+		t.Errorf("maxDepth = %d, want 2", maxDepth)
+	}
+	if maxChildren != 2 {
+		t.Errorf("maxChildren = %d, want 2", maxChildren)
+	}
+}
+
+func TestProcessTreeStatsCycleGuard(t *testing.T) {
+	// A cycle should never occur in a real process tree, but the walk must
+	// not hang if /proc is read mid-fork-bomb and produces something
+	// inconsistent.
+	ppidOf := map[int]int{
+		1: 2,
+		2: 1,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		processTreeStats(ppidOf)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("processTreeStats did not return; cycle guard failed to terminate the walk")
+	}
+}